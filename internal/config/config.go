@@ -1,8 +1,6 @@
 package config
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
 	"crypto/tls"
 	"fmt"
 	"net/mail"
@@ -10,10 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/email"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
@@ -47,6 +46,9 @@ const (
 	// auth
 	envJWTSecretKey     = "JWT_SECRET_KEY"
 	envRegistrationOpen = "OPEN_REGISTRATION"
+	// envJWTRotateGrace how long a rotated-out JWT signing key is still
+	// accepted for verification, e.g. "72h"
+	envJWTRotateGrace = "RM_JWT_ROTATE_GRACE"
 
 	// envSMTPServer the mail server
 	envSMTPServer = "RM_SMTP_SERVER"
@@ -64,6 +66,30 @@ const (
 	envSMTPInsecureTLS = "RM_SMTP_INSECURE_TLS"
 	// envSMTPFrom custom from address
 	envSMTPFrom = "RM_SMTP_FROM"
+	// envSMTPAuth the SASL mechanism: plain|login|cram-md5|xoauth2|none
+	envSMTPAuth = "RM_SMTP_AUTH"
+	// envSMTPURL a single smtp[s]://user:pass@host:port/?auth=... url, as an
+	// alternative to setting the individual RM_SMTP_* variables above
+	envSMTPURL = "RM_SMTP_URL"
+	// envSMTPOAuthToken a pre-fetched xoauth2 access token
+	envSMTPOAuthToken = "RM_SMTP_OAUTH_TOKEN"
+	// envSMTPOAuthCmd a command that prints a fresh xoauth2 access token
+	envSMTPOAuthCmd = "RM_SMTP_OAUTH_CMD"
+	// envSMTPDKIMKey a PEM private key (or path to one) used to DKIM-sign outgoing mail
+	envSMTPDKIMKey = "RM_SMTP_DKIM_KEY"
+	// envSMTPDKIMSelector the DKIM selector, e.g. "default"
+	envSMTPDKIMSelector = "RM_SMTP_DKIM_SELECTOR"
+
+	// envRenderer selects the exporter backend used to generate PDFs
+	envRenderer = "RM_RENDERER"
+
+	// envNotifyWebhookURL a generic webhook to POST document/user events to
+	envNotifyWebhookURL = "RM_NOTIFY_WEBHOOK_URL"
+	// envNotifyWebhookSecret signs webhook bodies with HMAC-SHA256 when set
+	envNotifyWebhookSecret = "RM_NOTIFY_WEBHOOK_SECRET"
+	// envNotifyEmailTo, when set along with SMTP, also emails document/user
+	// events to this address
+	envNotifyEmailTo = "RM_NOTIFY_EMAIL_TO"
 
 	// envHwrApplicationKey the myScript application key
 	envHwrApplicationKey = "RMAPI_HWR_APPLICATIONKEY"
@@ -91,14 +117,32 @@ type Config struct {
 	HWRHmac           string
 	HTTPSCookie       bool
 	TrustProxy        bool
+	// JWTRotateGrace is how long JWTPreviousSecretKey stays valid after a rotation
+	JWTRotateGrace time.Duration
+	// jwtMu guards JWTSecretKey, JWTPreviousSecretKey, JWTGraceUntil and
+	// JWTRandom, since RotateJWTSecret can mutate them concurrently with a
+	// request reading them through VerifyingKeys.
+	jwtMu sync.RWMutex
+	// JWTPreviousSecretKey, if non-nil and within JWTGraceUntil, is still
+	// accepted for verification so tokens survive a key rotation
+	JWTPreviousSecretKey []byte
+	JWTGraceUntil        time.Time
+	// Renderer is the exporter backend to use: poundifdef|rmapi|v6native|auto
+	Renderer string
+
+	// NotifyWebhookURL, when set, receives a signed POST for document/user events
+	NotifyWebhookURL string
+	// NotifyWebhookSecret signs NotifyWebhookURL bodies with HMAC-SHA256
+	NotifyWebhookSecret string
+	// NotifyEmailTo, when set, also emails document/user events to this
+	// address through SMTPConfig
+	NotifyEmailTo string
 }
 
 // Verify verify
 func (cfg *Config) Verify() {
 	if cfg.JWTRandom {
-		log.Warn("The authentication will fail the next time you start the server!")
-		log.Warnf("%s was not set! The following was autogenerated", envJWTSecretKey)
-		log.Warnf("%s=%X", envJWTSecretKey, cfg.JWTSecretKey)
+		log.Infof("%s was not set, generated and persisted a new JWT secret under %s", envJWTSecretKey, cfg.DataDir)
 	}
 
 	if !cfg.HTTPSCookie {
@@ -117,6 +161,22 @@ func (cfg *Config) Verify() {
 	}
 }
 
+// fileExists reports whether path exists and is a regular file.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// readDKIMKey reads the DKIM private key from the given value: a path to a
+// PEM file if one exists there, otherwise the value is treated as the PEM
+// content itself.
+func readDKIMKey(v string) ([]byte, error) {
+	if data, err := os.ReadFile(v); err == nil {
+		return data, nil
+	}
+	return []byte(v), nil
+}
+
 // FromEnv config from environment values
 func FromEnv() *Config {
 	var err error
@@ -137,16 +197,39 @@ func FromEnv() *Config {
 	}
 
 	jwtGenerated := false
-	jwtSecretKey := []byte(os.Getenv(envJWTSecretKey))
+	pinnedJWTSecretKey := os.Getenv(envJWTSecretKey)
+	jwtSecretKey := []byte(pinnedJWTSecretKey)
 	if len(jwtSecretKey) == 0 {
-		jwtSecretKey = make([]byte, 32)
-		_, err := rand.Read(jwtSecretKey)
+		jwtSecretKey, jwtGenerated, err = loadOrCreateJWTSecret(dataDir)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatal("jwt secret: ", err)
+		}
+	}
+
+	saltPath := filepath.Join(dataDir, jwtSaltFile)
+	saltExisted := fileExists(saltPath)
+	jwtSalt, err := loadOrCreateSalt(dataDir)
+	if err != nil {
+		log.Fatal("jwt salt: ", err)
+	}
+	dk := deriveKey(jwtSecretKey, jwtSalt)
+
+	jwtRotateGraceDuration := jwtRotateGrace()
+	var previousKey []byte
+	var graceUntil time.Time
+	if key, until, ok := loadRotationState(dataDir); ok {
+		previousKey, graceUntil = key, until
+	} else if !saltExisted && pinnedJWTSecretKey != "" {
+		// A JWT_SECRET_KEY was already pinned before jwt.salt existed, so it
+		// was previously derived with the old hardcoded salt/iterations.
+		// Accept tokens signed with that legacy key for one grace period so
+		// upgrading to this version doesn't log everyone out.
+		previousKey = legacyDeriveKey(jwtSecretKey)
+		graceUntil = time.Now().Add(jwtRotateGraceDuration)
+		if err := saveRotationState(dataDir, previousKey, graceUntil); err != nil {
+			log.Warn("can't persist jwt migration grace period: ", err)
 		}
-		jwtGenerated = true
 	}
-	dk := pbkdf2.Key(jwtSecretKey, []byte("todo some salt"), 10000, 32, sha256.New)
 
 	var cert tls.Certificate
 	certPath := os.Getenv(envTLSCert)
@@ -174,9 +257,12 @@ func FromEnv() *Config {
 
 	// smtp
 	var smtpCfg *email.SMTPConfig
-	servername := os.Getenv(envSMTPServer)
-
-	if servername != "" {
+	if smtpURL := os.Getenv(envSMTPURL); smtpURL != "" {
+		smtpCfg, err = email.ParseSMTPURL(smtpURL)
+		if err != nil {
+			log.Fatal(envSMTPURL, ": ", err)
+		}
+	} else if servername := os.Getenv(envSMTPServer); servername != "" {
 		inSecureTLS, _ := strconv.ParseBool(os.Getenv(envSMTPInsecureTLS))
 		noTLS, _ := strconv.ParseBool(os.Getenv(envSMTPNoTLS))
 		startTLS, _ := strconv.ParseBool(os.Getenv(envSMTPStartTLS))
@@ -188,7 +274,13 @@ func FromEnv() *Config {
 			NoTLS:       noTLS,
 			StartTLS:    startTLS,
 			InsecureTLS: inSecureTLS,
+			Auth:        os.Getenv(envSMTPAuth),
+			OAuthToken:  os.Getenv(envSMTPOAuthToken),
+			OAuthCmd:    os.Getenv(envSMTPOAuthCmd),
 		}
+	}
+
+	if smtpCfg != nil {
 		fromOverride := os.Getenv(envSMTPFrom)
 		if fromOverride != "" {
 			fromAddress, err := mail.ParseAddress(os.Getenv(envSMTPFrom))
@@ -198,23 +290,45 @@ func FromEnv() *Config {
 				smtpCfg.FromOverride = fromAddress
 			}
 		}
+
+		if dkimKey := os.Getenv(envSMTPDKIMKey); dkimKey != "" {
+			keyBytes, err := readDKIMKey(dkimKey)
+			if err != nil {
+				log.Warn(envSMTPDKIMKey, ": ", err)
+			} else {
+				smtpCfg.DKIMPrivateKeyPEM = keyBytes
+				smtpCfg.DKIMSelector = os.Getenv(envSMTPDKIMSelector)
+			}
+		}
 	}
 
 	trustProxy, _ := strconv.ParseBool(os.Getenv(envTrustProxy))
 
+	renderer := os.Getenv(envRenderer)
+	if renderer == "" {
+		renderer = "auto"
+	}
+
 	cfg := Config{
-		Port:              port,
-		StorageURL:        uploadURL,
-		DataDir:           dataDir,
-		JWTSecretKey:      dk,
-		JWTRandom:         jwtGenerated,
-		Certificate:       cert,
-		RegistrationOpen:  openRegistration,
-		SMTPConfig:        smtpCfg,
-		HWRApplicationKey: os.Getenv(envHwrApplicationKey),
-		HWRHmac:           os.Getenv(envHwrHmac),
-		HTTPSCookie:       httpsCookie,
-		TrustProxy:        trustProxy,
+		Port:                 port,
+		StorageURL:           uploadURL,
+		DataDir:              dataDir,
+		JWTSecretKey:         dk,
+		JWTRandom:            jwtGenerated,
+		JWTRotateGrace:       jwtRotateGraceDuration,
+		JWTPreviousSecretKey: previousKey,
+		JWTGraceUntil:        graceUntil,
+		Certificate:          cert,
+		RegistrationOpen:     openRegistration,
+		SMTPConfig:           smtpCfg,
+		HWRApplicationKey:    os.Getenv(envHwrApplicationKey),
+		HWRHmac:              os.Getenv(envHwrHmac),
+		HTTPSCookie:          httpsCookie,
+		TrustProxy:           trustProxy,
+		Renderer:             renderer,
+		NotifyWebhookURL:     os.Getenv(envNotifyWebhookURL),
+		NotifyWebhookSecret:  os.Getenv(envNotifyWebhookSecret),
+		NotifyEmailTo:        os.Getenv(envNotifyEmailTo),
 	}
 	return &cfg
 }
@@ -225,7 +339,8 @@ func EnvVars() string {
 Environment Variables:
 
 General:
-	%s	Secret for signing JWT tokens
+	%s	Secret for signing JWT tokens (autogenerated and persisted to DATADIR if unset)
+	%s	How long a rotated-out JWT key is still accepted for verification (default: 72h)
 	%s	Url the tablet can resolve (default: %s)
 			needs to be set to the hostname or proxy if behind a proxy
 			especially if you want other tools to work (eg rmapi)
@@ -239,6 +354,10 @@ General:
 	%s	Write logs to file
 	%s Send auth cookie only via https
 	%s	Trust the proxy for X-Forwarded-For/X-Real-IP (set only if behind a proxy)
+	%s	PDF exporter backend: poundifdef|rmapi|v6native|auto (default: auto)
+	%s	Webhook url to POST document/user events to
+	%s	Sign the webhook body with HMAC-SHA256 using this secret
+	%s	Also email document/user events to this address (needs smtp configured)
 
 Emails, smtp:
 	%s
@@ -248,12 +367,19 @@ Emails, smtp:
 	%s	don't check the server certificate (not recommended)
 	%s	custom HELO (if your email server needs it)
 	%s	override the email's From:
+	%s	auth mechanism: plain|login|cram-md5|xoauth2|none (default: plain)
+	%s	smtp[s]://user:pass@host:port/?auth=... instead of the above
+	%s	xoauth2 access token
+	%s	command that prints a fresh xoauth2 access token
+	%s	PEM private key (or path to one) to DKIM-sign outgoing mail
+	%s	DKIM selector (default: default)
 
 myScript hwr (needs a developer account):
 	%s
 	%s
 `,
 		envJWTSecretKey,
+		envJWTRotateGrace,
 		EnvStorageURL,
 		DefaultHost,
 		EnvLogLevel,
@@ -267,6 +393,10 @@ myScript hwr (needs a developer account):
 		EnvLogFile,
 		envHTTPSCookie,
 		envTrustProxy,
+		envRenderer,
+		envNotifyWebhookURL,
+		envNotifyWebhookSecret,
+		envNotifyEmailTo,
 
 		envSMTPServer,
 		envSMTPUsername,
@@ -275,6 +405,12 @@ myScript hwr (needs a developer account):
 		envSMTPInsecureTLS,
 		envSMTPHelo,
 		envSMTPFrom,
+		envSMTPAuth,
+		envSMTPURL,
+		envSMTPOAuthToken,
+		envSMTPOAuthCmd,
+		envSMTPDKIMKey,
+		envSMTPDKIMSelector,
 
 		envHwrApplicationKey,
 		envHwrHmac,
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// jwtSecretFile stores the raw, autogenerated JWT signing secret so it
+	// survives a restart instead of invalidating every session.
+	jwtSecretFile = "jwt.key"
+	// jwtSaltFile stores the per-instance PBKDF2 salt.
+	jwtSaltFile = "jwt.salt"
+	// jwtRotationFile records the previous derived signing key and the
+	// deadline until which it's still accepted, so a rotation doesn't immediately log
+	// out every signed-in device.
+	jwtRotationFile = "jwt.rotation"
+
+	// jwtPBKDF2Iterations follows current OWASP guidance for PBKDF2-SHA256.
+	jwtPBKDF2Iterations = 600_000
+	jwtKeyLength        = 32
+
+	// defaultJWTRotateGrace is used when RM_JWT_ROTATE_GRACE is unset or invalid.
+	defaultJWTRotateGrace = 72 * time.Hour
+)
+
+// loadOrCreateJWTSecret returns the persisted raw JWT secret from
+// dataDir/jwt.key, generating and persisting (chmod 0600) a new random one
+// on first run.
+func loadOrCreateJWTSecret(dataDir string) (secret []byte, generated bool, err error) {
+	path := filepath.Join(dataDir, jwtSecretFile)
+
+	if data, err := os.ReadFile(path); err == nil {
+		return data, false, nil
+	} else if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("can't read %s: %w", path, err)
+	}
+
+	secret = make([]byte, jwtKeyLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, false, err
+	}
+
+	if err := writeSecretFile(path, secret); err != nil {
+		return nil, false, err
+	}
+
+	return secret, true, nil
+}
+
+// loadOrCreateSalt returns the persisted PBKDF2 salt from dataDir/jwt.salt,
+// generating and persisting a new random one on first run, replacing the
+// previously hardcoded literal salt.
+func loadOrCreateSalt(dataDir string) ([]byte, error) {
+	path := filepath.Join(dataDir, jwtSaltFile)
+
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't read %s: %w", path, err)
+	}
+
+	salt := make([]byte, jwtKeyLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if err := writeSecretFile(path, salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+func writeSecretFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("can't create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("can't write %s: %w", path, err)
+	}
+	return nil
+}
+
+// deriveKey derives the JWT signing key from secret+salt with the current
+// (hardened) PBKDF2 parameters.
+func deriveKey(secret, salt []byte) []byte {
+	return pbkdf2.Key(secret, salt, jwtPBKDF2Iterations, jwtKeyLength, sha256.New)
+}
+
+// legacySalt and legacyIterations are the hardcoded salt and low iteration
+// count used to derive the JWT signing key before jwt.salt/jwtPBKDF2Iterations
+// existed. legacyDeriveKey reproduces that derivation so a deployment
+// migrating a pinned JWT_SECRET_KEY onto the new scheme can keep verifying
+// tokens it already issued during the grace period.
+var legacySalt = []byte("todo some salt")
+
+const legacyIterations = 10000
+
+func legacyDeriveKey(secret []byte) []byte {
+	return pbkdf2.Key(secret, legacySalt, legacyIterations, jwtKeyLength, sha256.New)
+}
+
+// jwtRotateGrace parses RM_JWT_ROTATE_GRACE, e.g. "72h", falling back to
+// defaultJWTRotateGrace.
+func jwtRotateGrace() time.Duration {
+	v := os.Getenv(envJWTRotateGrace)
+	if v == "" {
+		return defaultJWTRotateGrace
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warnf("%s=%q is not a valid duration, using %s", envJWTRotateGrace, v, defaultJWTRotateGrace)
+		return defaultJWTRotateGrace
+	}
+	return d
+}
+
+// loadRotationState reads a pending previous-key grace period left over
+// from an earlier rotation, if any and if it hasn't expired yet.
+func loadRotationState(dataDir string) (previousKey []byte, graceUntil time.Time, ok bool) {
+	path := filepath.Join(dataDir, jwtRotationFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	if len(lines) != 2 {
+		return nil, time.Time{}, false
+	}
+
+	unixSeconds, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	until := time.Unix(unixSeconds, 0)
+	if time.Now().After(until) {
+		os.Remove(path)
+		return nil, time.Time{}, false
+	}
+
+	key, err := hex.DecodeString(lines[1])
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	return key, until, true
+}
+
+func saveRotationState(dataDir string, previousKey []byte, graceUntil time.Time) error {
+	path := filepath.Join(dataDir, jwtRotationFile)
+	content := fmt.Sprintf("%d\n%s", graceUntil.Unix(), hex.EncodeToString(previousKey))
+	return writeSecretFile(path, []byte(content))
+}
+
+// RotateJWTSecret forces a new JWT secret and salt to be generated and
+// persisted, keeping the previously-derived signing key valid for
+// verification until cfg.JWTGraceUntil so already-issued tokens survive the
+// rotation. Backs the `POST /admin/jwt/rotate` endpoint.
+func RotateJWTSecret(cfg *Config) error {
+	newSecret := make([]byte, jwtKeyLength)
+	if _, err := rand.Read(newSecret); err != nil {
+		return err
+	}
+	newSalt := make([]byte, jwtKeyLength)
+	if _, err := rand.Read(newSalt); err != nil {
+		return err
+	}
+
+	cfg.jwtMu.Lock()
+	defer cfg.jwtMu.Unlock()
+
+	graceUntil := time.Now().Add(cfg.JWTRotateGrace)
+	if err := saveRotationState(cfg.DataDir, cfg.JWTSecretKey, graceUntil); err != nil {
+		return fmt.Errorf("can't persist rotation state: %w", err)
+	}
+
+	if err := writeSecretFile(filepath.Join(cfg.DataDir, jwtSecretFile), newSecret); err != nil {
+		return err
+	}
+	if err := writeSecretFile(filepath.Join(cfg.DataDir, jwtSaltFile), newSalt); err != nil {
+		return err
+	}
+
+	cfg.JWTPreviousSecretKey = cfg.JWTSecretKey
+	cfg.JWTGraceUntil = graceUntil
+	cfg.JWTSecretKey = deriveKey(newSecret, newSalt)
+	cfg.JWTRandom = false
+
+	return nil
+}
+
+// VerifyingKeys returns every key a token's signature should be checked
+// against: the current JWTSecretKey, plus JWTPreviousSecretKey while
+// JWTGraceUntil hasn't passed, so tokens issued before a rotation (or
+// before migrating a pinned JWT_SECRET_KEY onto PBKDF2) still verify
+// during the grace window instead of forcing every device to re-log-in.
+func (cfg *Config) VerifyingKeys() [][]byte {
+	cfg.jwtMu.RLock()
+	defer cfg.jwtMu.RUnlock()
+
+	keys := [][]byte{cfg.JWTSecretKey}
+	if len(cfg.JWTPreviousSecretKey) > 0 && time.Now().Before(cfg.JWTGraceUntil) {
+		keys = append(keys, cfg.JWTPreviousSecretKey)
+	}
+	return keys
+}
+
+// RotateJWTHandler handles POST /admin/jwt/rotate: it rotates the JWT
+// signing key via RotateJWTSecret and, on success, calls broadcastReauth so
+// callers can e.g. notify connected devices that they'll need to refresh
+// their session once JWTGraceUntil passes.
+func RotateJWTHandler(cfg *Config, broadcastReauth func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := RotateJWTSecret(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if broadcastReauth != nil {
+			broadcastReauth()
+		}
+		cfg.jwtMu.RLock()
+		graceUntil := cfg.JWTGraceUntil
+		cfg.jwtMu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"graceUntil": graceUntil.Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dkimHeaders are the headers included in the signature, in order.
+var dkimHeaders = []string{"From", "To", "Subject"}
+
+// dkimSign produces msg with a DKIM-Signature header (relaxed/relaxed,
+// rsa-sha256) prepended, per RFC 6376.
+func dkimSign(cfg *SMTPConfig, from string, msg []byte) ([]byte, error) {
+	key, err := parseDKIMKey(cfg.DKIMPrivateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse dkim key: %w", err)
+	}
+
+	domain := domainOf(from)
+	if domain == "" {
+		return nil, fmt.Errorf("can't derive dkim domain from %q", from)
+	}
+	selector := cfg.DKIMSelector
+	if selector == "" {
+		selector = "default"
+	}
+
+	header, body := splitMessage(msg)
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+
+	sig := fmt.Sprintf(
+		"v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; h=%s; bh=%s; b=",
+		domain, selector, strings.Join(dkimHeaders, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+
+	signingInput := buildSigningInput(header, sig)
+	digest := sha256.Sum256(signingInput)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("can't sign dkim digest: %w", err)
+	}
+
+	sig += base64.StdEncoding.EncodeToString(signature)
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: " + sig + "\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+func parseDKIMKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("dkim key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+var addressDomain = regexp.MustCompile(`@([^@>\s]+)$`)
+
+func domainOf(address string) string {
+	m := addressDomain.FindStringSubmatch(strings.TrimSpace(address))
+	if len(m) != 2 {
+		return ""
+	}
+	return m[1]
+}
+
+func splitMessage(msg []byte) (header, body []byte) {
+	parts := bytes.SplitN(msg, []byte("\r\n\r\n"), 2)
+	if len(parts) != 2 {
+		return msg, nil
+	}
+	return parts[0], parts[1]
+}
+
+// buildSigningInput canonicalizes each signed header (relaxed) followed by
+// the DKIM-Signature header itself (with an empty b= tag), per RFC 6376
+// section 3.7.
+func buildSigningInput(header []byte, dkimSigValue string) []byte {
+	fields := map[string]string{}
+	for _, line := range splitHeaderFields(header) {
+		name, value, ok := splitHeaderLine(line)
+		if ok {
+			fields[strings.ToLower(name)] = value
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, name := range dkimHeaders {
+		value := fields[strings.ToLower(name)]
+		buf.WriteString(canonicalizeHeaderRelaxed(name, value))
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString(canonicalizeHeaderRelaxed("DKIM-Signature", " "+dkimSigValue))
+	return buf.Bytes()
+}
+
+func splitHeaderFields(header []byte) []string {
+	unfolded := regexp.MustCompile(`\r\n[ \t]+`).ReplaceAll(header, []byte(" "))
+	return strings.Split(string(unfolded), "\r\n")
+}
+
+func splitHeaderLine(line string) (name, value string, ok bool) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	return line[:idx], line[idx+1:], true
+}
+
+var wsRun = regexp.MustCompile(`[ \t]+`)
+
+// canonicalizeHeaderRelaxed implements RFC 6376 3.4.2 relaxed header
+// canonicalization for a single header field.
+func canonicalizeHeaderRelaxed(name, value string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = wsRun.ReplaceAllString(strings.TrimSpace(value), " ")
+	return name + ":" + value
+}
+
+// canonicalizeBodyRelaxed implements RFC 6376 3.4.4 relaxed body
+// canonicalization.
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wsRun.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,335 @@
+// Package email sends outgoing mail (password resets, document-by-email,
+// sharing notifications, ...) through a configured SMTP relay.
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SMTPConfig configures the outgoing mail relay.
+type SMTPConfig struct {
+	Server       string
+	Username     string
+	Password     string
+	Helo         string
+	NoTLS        bool
+	InsecureTLS  bool
+	StartTLS     bool
+	FromOverride *mail.Address
+
+	// Auth selects the SASL mechanism: plain|login|cram-md5|xoauth2|none.
+	// Empty defaults to "plain" for backwards compatibility.
+	Auth string
+	// OAuthToken is used as-is for xoauth2 when set.
+	OAuthToken string
+	// OAuthCmd is run to fetch a fresh xoauth2 access token when OAuthToken
+	// is empty, e.g. `gcloud auth print-access-token`.
+	OAuthCmd string
+
+	// DKIMPrivateKeyPEM, when set, enables signing outgoing mail.
+	DKIMPrivateKeyPEM []byte
+	// DKIMSelector is the DKIM selector, e.g. "default" for
+	// default._domainkey.example.com.
+	DKIMSelector string
+}
+
+// Message is a single outgoing email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+
+	AttachmentName string
+	Attachment     []byte
+}
+
+// Send delivers msg through cfg. It picks the configured SASL mechanism,
+// optionally upgrades the connection with StartTLS, and DKIM-signs the
+// message when a signing key is configured.
+func Send(cfg *SMTPConfig, msg *Message) error {
+	if cfg == nil {
+		return fmt.Errorf("smtp not configured")
+	}
+
+	from := "rmfakecloud@" + hostOnly(cfg.Server)
+	if cfg.FromOverride != nil {
+		from = cfg.FromOverride.Address
+	}
+
+	raw, err := buildMessage(from, msg)
+	if err != nil {
+		return fmt.Errorf("can't build message: %w", err)
+	}
+
+	if len(cfg.DKIMPrivateKeyPEM) > 0 {
+		signed, err := dkimSign(cfg, from, raw)
+		if err != nil {
+			log.Warn("dkim signing failed, sending unsigned: ", err)
+		} else {
+			raw = signed
+		}
+	}
+
+	auth, err := resolveAuth(cfg)
+	if err != nil {
+		return fmt.Errorf("can't build smtp auth: %w", err)
+	}
+
+	return sendMail(cfg, from, []string{msg.To}, raw, auth)
+}
+
+func buildMessage(from string, msg *Message) ([]byte, error) {
+	if len(msg.Attachment) > 0 {
+		return buildMultipartMessage(from, msg)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n")
+	return []byte(b.String()), nil
+}
+
+// buildMultipartMessage builds a multipart/mixed message with the body as
+// a text/plain part and msg.Attachment base64-encoded as a second part, so
+// a set Attachment is never silently dropped.
+func buildMultipartMessage(from string, msg *Message) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	textPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := textPart.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+
+	name := msg.AttachmentName
+	if name == "" {
+		name = "attachment"
+	}
+	attachmentPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/octet-stream"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf(`attachment; filename=%q`, name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, attachmentPart)
+	if _, err := encoder.Write(msg.Attachment); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n", mw.Boundary())
+	b.WriteString("\r\n")
+	b.Write(body.Bytes())
+	return []byte(b.String()), nil
+}
+
+func hostOnly(server string) string {
+	host, _, err := splitHostPort(server)
+	if err != nil {
+		return server
+	}
+	return host
+}
+
+func splitHostPort(server string) (host, port string, err error) {
+	parts := strings.SplitN(server, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	}
+	return parts[0], "", nil
+}
+
+// resolveAuth builds the smtp.Auth for the configured mechanism. An empty
+// Auth defaults to plain, matching the historical implicit behavior.
+func resolveAuth(cfg *SMTPConfig) (smtp.Auth, error) {
+	mechanism := strings.ToLower(cfg.Auth)
+	if mechanism == "" {
+		mechanism = "plain"
+	}
+
+	host := hostOnly(cfg.Server)
+
+	switch mechanism {
+	case "none":
+		return nil, nil
+	case "plain":
+		if cfg.Username == "" {
+			return nil, nil
+		}
+		return smtp.PlainAuth("", cfg.Username, cfg.Password, host), nil
+	case "login":
+		return &loginAuth{username: cfg.Username, password: cfg.Password}, nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(cfg.Username, cfg.Password), nil
+	case "xoauth2":
+		token, err := resolveOAuthToken(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &xoauth2Auth{username: cfg.Username, token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown smtp auth mechanism %q", cfg.Auth)
+	}
+}
+
+func resolveOAuthToken(cfg *SMTPConfig) (string, error) {
+	if cfg.OAuthToken != "" {
+		return cfg.OAuthToken, nil
+	}
+	if cfg.OAuthCmd != "" {
+		return runOAuthCmd(cfg.OAuthCmd)
+	}
+	return "", fmt.Errorf("xoauth2 auth needs RM_SMTP_OAUTH_TOKEN or RM_SMTP_OAUTH_CMD")
+}
+
+// loginAuth implements the SMTP AUTH LOGIN challenge/response exchange,
+// which the standard library's net/smtp does not ship.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.TrimSuffix(string(fromServer), ":") {
+	case "Username":
+		return []byte(a.username), nil
+	case "Password":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected login server prompt %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements the SASL XOAUTH2 mechanism used by providers like
+// Gmail/Office365: `user=<user>\x01auth=Bearer <token>\x01\x01`.
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	blob := fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", a.username, a.token)
+	return "XOAUTH2", []byte(blob), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// server rejected the token and is sending a JSON error continuation;
+		// respond with an empty message so it fails cleanly instead of hanging.
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// sendMail mirrors smtp.SendMail but adds our own TLS/StartTLS/InsecureTLS
+// handling, since the stdlib helper always dials with StartTLS-or-nothing
+// and offers no escape hatch for self-signed certs or plaintext testing.
+func sendMail(cfg *SMTPConfig, from string, to []string, msg []byte, auth smtp.Auth) error {
+	host, _, err := splitHostPort(cfg.Server)
+	if err != nil {
+		return err
+	}
+
+	var c *smtp.Client
+	if !cfg.NoTLS && !cfg.StartTLS {
+		// default: implicit TLS from the first byte (e.g. port 465)
+		conn, err := tls.Dial("tcp", cfg.Server, &tls.Config{ServerName: host, InsecureSkipVerify: cfg.InsecureTLS})
+		if err != nil {
+			return fmt.Errorf("tls dial failed: %w", err)
+		}
+		c, err = smtp.NewClient(conn, host)
+		if err != nil {
+			return err
+		}
+	} else {
+		c, err = smtp.Dial(cfg.Server)
+		if err != nil {
+			return err
+		}
+	}
+	defer c.Close()
+
+	if cfg.Helo != "" {
+		if err := c.Hello(cfg.Helo); err != nil {
+			return err
+		}
+	}
+
+	if cfg.StartTLS {
+		tlsCfg := &tls.Config{ServerName: host, InsecureSkipVerify: cfg.InsecureTLS}
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsCfg); err != nil {
+				return fmt.Errorf("starttls failed: %w", err)
+			}
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return fmt.Errorf("smtp auth failed: %w", err)
+			}
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
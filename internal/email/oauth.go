@@ -0,0 +1,17 @@
+package email
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// runOAuthCmd runs cmd through the shell and returns its trimmed stdout as
+// the XOAUTH2 bearer token, so tokens can come from `gcloud auth
+// print-access-token` or similar rather than a long-lived secret in env.
+func runOAuthCmd(cmd string) (string, error) {
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,62 @@
+package email
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ParseSMTPURL parses a single-URL SMTP configuration of the form
+// smtp[s]://user:pass@host:port/?auth=login&helo=example.com, as an
+// alternative to setting the individual RM_SMTP_* variables.
+func ParseSMTPURL(raw string) (*SMTPConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse smtp url: %w", err)
+	}
+
+	var cfg SMTPConfig
+	// smtp:// defaults to opportunistic StartTLS on a plaintext connection;
+	// smtps:// defaults to implicit TLS from the first byte (e.g. port 465).
+	switch u.Scheme {
+	case "smtp":
+		cfg.StartTLS = true
+	case "smtps":
+	default:
+		return nil, fmt.Errorf("unsupported smtp url scheme %q, want smtp:// or smtps://", u.Scheme)
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "smtps" {
+			port = "465"
+		} else {
+			port = "587"
+		}
+	}
+	cfg.Server = host + ":" + port
+
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+
+	q := u.Query()
+	cfg.Auth = q.Get("auth")
+	cfg.Helo = q.Get("helo")
+	cfg.OAuthToken = q.Get("oauth_token")
+	cfg.OAuthCmd = q.Get("oauth_cmd")
+
+	if v := q.Get("notls"); v != "" {
+		cfg.NoTLS, _ = strconv.ParseBool(v)
+	}
+	if v := q.Get("starttls"); v != "" {
+		cfg.StartTLS, _ = strconv.ParseBool(v)
+	}
+	if v := q.Get("insecure"); v != "" {
+		cfg.InsecureTLS, _ = strconv.ParseBool(v)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,54 @@
+package notifications
+
+import (
+	"context"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+)
+
+// FromConfig builds a Dispatcher wired up from cfg (the generic webhook sink
+// and, with SMTP configured, the NotifyEmailTo email sink; per-user sinks are
+// added later via AddUserSink as users configure them) and starts it running
+// in the background until ctx
+// is canceled. Call this once at startup and keep the returned Dispatcher
+// around to Publish from the upload/rename/delete/registration handlers.
+func FromConfig(ctx context.Context, cfg *config.Config) *Dispatcher {
+	d := NewDispatcher(0)
+
+	if cfg.NotifyWebhookURL != "" {
+		d.AddSink(NewWebhookSink(cfg.NotifyWebhookURL, cfg.NotifyWebhookSecret))
+	}
+
+	if cfg.NotifyEmailTo != "" && cfg.SMTPConfig != nil {
+		d.AddSink(&EmailSink{Config: cfg.SMTPConfig, To: cfg.NotifyEmailTo})
+	}
+
+	go d.Run(ctx)
+	return d
+}
+
+// AddUserSink registers the ntfy push sink backing one user's notification
+// preferences once they've configured it, so events published afterwards
+// fan out to it too. An empty ntfyTopicURL registers nothing.
+func (d *Dispatcher) AddUserSink(userID, ntfyTopicURL, ntfyBasicAuthB64 string) {
+	if ntfyTopicURL == "" {
+		return
+	}
+	d.AddSink(&userScopedSink{userID: userID, sink: NewNtfySink(ntfyTopicURL, ntfyBasicAuthB64)})
+}
+
+// userScopedSink only forwards events for the user it's registered to,
+// since a Dispatcher's sinks are otherwise global.
+type userScopedSink struct {
+	userID string
+	sink   Sink
+}
+
+func (u *userScopedSink) Name() string { return u.sink.Name() + ":" + u.userID }
+
+func (u *userScopedSink) Send(ctx context.Context, event Event) error {
+	if event.UserID != u.userID {
+		return nil
+	}
+	return u.sink.Send(ctx, event)
+}
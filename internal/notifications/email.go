@@ -0,0 +1,25 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ddvk/rmfakecloud/internal/email"
+)
+
+// EmailSink delivers events as plain emails through the existing SMTP
+// configuration, for users who'd rather get a notification in their inbox.
+type EmailSink struct {
+	Config *email.SMTPConfig
+	To     string
+}
+
+func (e *EmailSink) Name() string { return "email" }
+
+func (e *EmailSink) Send(_ context.Context, event Event) error {
+	return email.Send(e.Config, &email.Message{
+		To:      e.To,
+		Subject: fmt.Sprintf("rmfakecloud: %s", event.Type),
+		Body:    event.Payload["message"],
+	})
+}
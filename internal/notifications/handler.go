@@ -0,0 +1,53 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ntfyConfigRequest is the body accepted by SaveNtfyConfigHandler, matching
+// what a settings page would let a user paste in: their ntfy topic URL and
+// optional Basic-auth credentials for self-hosted/private topics.
+type ntfyConfigRequest struct {
+	TopicURL string `json:"topicUrl"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SaveNtfyConfigHandler lets a signed-in user save their own ntfy topic URL
+// and optional Basic-auth credentials, then registers a sink on d so future
+// events are delivered to them. userIDFromRequest resolves the caller's
+// user ID the same way the rest of the API does (e.g. from the session),
+// and persist is called so the preference survives a restart.
+func SaveNtfyConfigHandler(d *Dispatcher, userIDFromRequest func(r *http.Request) (string, error), persist func(userID, topicURL, basicAuthB64 string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := userIDFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var req ntfyConfigRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.TopicURL == "" {
+			http.Error(w, "topicUrl is required", http.StatusBadRequest)
+			return
+		}
+
+		var basicAuthB64 string
+		if req.Username != "" {
+			basicAuthB64 = EncodeBasicAuth(req.Username, req.Password)
+		}
+
+		if err := persist(userID, req.TopicURL, basicAuthB64); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d.AddUserSink(userID, req.TopicURL, basicAuthB64)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,129 @@
+// Package notifications fans out document and account events to
+// configurable external sinks (webhooks, ntfy-style push, email), so
+// rmfakecloud can drive IFTTT/Home Assistant-style automation around
+// reMarkable activity.
+package notifications
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event types published by handlers as things happen.
+const (
+	EventDocumentUploaded = "document.uploaded"
+	EventDocumentShared   = "document.shared"
+	EventUserRegistered   = "user.registered"
+	EventEmailSent        = "email.sent"
+)
+
+// Event is a single notification fanned out to every registered Sink.
+type Event struct {
+	Type    string
+	UserID  string
+	Time    time.Time
+	Payload map[string]string
+}
+
+// Sink delivers an Event to one external destination.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+const (
+	defaultQueueSize = 256
+	maxRetries       = 5
+	baseBackoff      = 2 * time.Second
+)
+
+// Dispatcher fans events out to its registered sinks on a background worker,
+// so callers (upload/rename/delete/registration handlers) never block on a
+// slow or unreachable sink. The queue is bounded: once full, new events are
+// dropped and logged rather than applying backpressure to the handler.
+type Dispatcher struct {
+	mu    sync.RWMutex
+	sinks []Sink
+	queue chan Event
+	done  chan struct{}
+}
+
+// NewDispatcher creates a Dispatcher with a bounded queue of the given size.
+// A size <= 0 uses defaultQueueSize.
+func NewDispatcher(queueSize int) *Dispatcher {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	return &Dispatcher{
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// AddSink registers a Sink. Safe to call concurrently with Publish/Run, so
+// sinks can be added at runtime as users configure their own (e.g. via
+// SaveNtfyConfigHandler) rather than only at startup.
+func (d *Dispatcher) AddSink(sink Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sinks = append(d.sinks, sink)
+}
+
+// Publish enqueues event for delivery. It never blocks: if the queue is
+// full the event is dropped and a warning is logged.
+func (d *Dispatcher) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case d.queue <- event:
+	default:
+		log.Warnf("notifications: queue full, dropping %s event for user %s", event.Type, event.UserID)
+	}
+}
+
+// Run processes queued events until ctx is canceled. It's meant to be
+// started once in a goroutine at startup.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			close(d.done)
+			return
+		case event := <-d.queue:
+			d.deliver(ctx, event)
+		}
+	}
+}
+
+// deliver sends event to every sink, retrying each with exponential backoff
+// up to maxRetries before giving up and logging the failure.
+func (d *Dispatcher) deliver(ctx context.Context, event Event) {
+	d.mu.RLock()
+	sinks := make([]Sink, len(d.sinks))
+	copy(sinks, d.sinks)
+	d.mu.RUnlock()
+
+	for _, sink := range sinks {
+		sink := sink
+		go func() {
+			var err error
+			for attempt := 0; attempt < maxRetries; attempt++ {
+				if attempt > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(baseBackoff * time.Duration(1<<uint(attempt-1))):
+					}
+				}
+				if err = sink.Send(ctx, event); err == nil {
+					return
+				}
+			}
+			log.Warnf("notifications: %s sink failed for %s after %d attempts: %v", sink.Name(), event.Type, maxRetries, err)
+		}()
+	}
+}
@@ -0,0 +1,78 @@
+package notifications
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultSinkTimeout = 10 * time.Second
+
+// NtfySink posts a plain-text message to a user's ntfy.sh-style topic URL,
+// with optional HTTP Basic auth for self-hosted/private topics.
+type NtfySink struct {
+	TopicURL string
+	// BasicAuthB64 is "username:password" base64-encoded, as stored on the
+	// user profile.
+	BasicAuthB64 string
+	Client       *http.Client
+}
+
+// NewNtfySink builds an NtfySink with a sane default HTTP client.
+func NewNtfySink(topicURL, basicAuthB64 string) *NtfySink {
+	return &NtfySink{TopicURL: topicURL, BasicAuthB64: basicAuthB64, Client: &http.Client{Timeout: defaultSinkTimeout}}
+}
+
+func (n *NtfySink) Name() string { return "ntfy" }
+
+func (n *NtfySink) Send(ctx context.Context, event Event) error {
+	body := fmt.Sprintf("%s: %s", event.Type, event.Payload["message"])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.TopicURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", "rmfakecloud")
+
+	if n.BasicAuthB64 != "" {
+		username, password, err := DecodeBasicAuth(n.BasicAuthB64)
+		if err != nil {
+			return fmt.Errorf("invalid ntfy basic auth: %w", err)
+		}
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ntfy returned %s", resp.Status)
+	}
+	return nil
+}
+
+// DecodeBasicAuth decodes a "username:password" pair stored base64-encoded
+// on the user profile, as used for the ntfy topic's optional Basic auth.
+func DecodeBasicAuth(b64 string) (username, password string, err error) {
+	decoded, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected \"username:password\"")
+	}
+	return parts[0], parts[1], nil
+}
+
+// EncodeBasicAuth is the inverse of DecodeBasicAuth, used when a user saves
+// ntfy credentials from the UI.
+func EncodeBasicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
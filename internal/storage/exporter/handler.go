@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// rendererQueryParam is the query parameter the PDF-download handler reads
+// to let a caller pick a renderer per-document, e.g. "?renderer=v6native".
+const rendererQueryParam = "renderer"
+
+// RouteDownload is the path DownloadHandler is mounted at by default.
+const RouteDownload = "/storage/export"
+
+// DownloadHandler wraps the existing PDF-download handler's archive lookup
+// with renderer selection: it reads rendererQueryParam off the request
+// (falling back to defaultRenderer, normally cfg.Renderer) and renders
+// through RenderWithBackend instead of calling a single hardcoded backend.
+func DownloadHandler(defaultRenderer string, resolveArchive func(r *http.Request) (*MyArchive, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archive, err := resolveArchive(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		name := r.URL.Query().Get(rendererQueryParam)
+		if name == "" {
+			name = defaultRenderer
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		if err := RenderWithBackend(r.Context(), name, archive, w, RenderOptions{AllPages: true}); err != nil {
+			http.Error(w, fmt.Sprintf("can't render with %q: %v", name, err), http.StatusInternalServerError)
+		}
+	}
+}
+
+var (
+	resolverMu      sync.RWMutex
+	defaultRenderer = RendererAuto
+	archiveResolver = func(r *http.Request) (*MyArchive, error) {
+		return nil, errors.New("exporter: no archive resolver configured, call SetArchiveResolver at startup")
+	}
+)
+
+// SetArchiveResolver plugs in the real archive lookup (by document ID,
+// authenticated user, ...) backing the route DownloadHandler is
+// self-mounted on. Call it once at startup, before serving any requests;
+// until it's called, the route responds 404 instead of panicking.
+func SetArchiveResolver(defaultRendererName string, resolve func(r *http.Request) (*MyArchive, error)) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	if defaultRendererName != "" {
+		defaultRenderer = defaultRendererName
+	}
+	archiveResolver = resolve
+}
+
+func init() {
+	// Self-mount the same way renderers self-register with Register() in
+	// registry.go: importing this package is enough to make the renderer
+	// query param reachable on http.DefaultServeMux, without depending on a
+	// router/bootstrap package that wires it by hand.
+	http.HandleFunc(RouteDownload, func(w http.ResponseWriter, r *http.Request) {
+		resolverMu.RLock()
+		name, resolve := defaultRenderer, archiveResolver
+		resolverMu.RUnlock()
+		DownloadHandler(name, resolve)(w, r)
+	})
+}
@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// RenderOptions are the common knobs every Renderer implementation accepts.
+type RenderOptions struct {
+	// AllPages renders the whole notebook instead of just the current page.
+	AllPages bool
+}
+
+// Capabilities describes what a Renderer is able to honor, so callers (and
+// "auto" selection) can pick the best backend for a given archive.
+type Capabilities struct {
+	Pressure  bool
+	PenTypes  bool
+	Templates bool
+	Color     bool
+}
+
+// Renderer is an exporter backend. Implementations are registered with
+// Register and looked up by name with Get, so new backends (SVG, PNG,
+// annotated-PDF, ...) can be added without touching call sites.
+type Renderer interface {
+	// Name is the renderer's unique, lowercase registry key, e.g. "v6native".
+	Name() string
+	// Supports reports whether the renderer can handle the given notebook
+	// format version (5 or 6).
+	Supports(version int) bool
+	Capabilities() Capabilities
+	Render(ctx context.Context, archive *MyArchive, out io.Writer, opts RenderOptions) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Renderer{}
+)
+
+// Register adds a Renderer to the registry under its own Name(). Registering
+// a renderer under a name that is already taken overwrites the previous one.
+func Register(r Renderer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[r.Name()] = r
+}
+
+// Get looks up a previously registered Renderer by name.
+func Get(name string) (Renderer, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[name]
+	return r, ok
+}
+
+// Names returns the names of all registered renderers, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(&poundifdefRenderer{})
+	Register(&v6NativeRenderer{})
+	Register(&rmapiRenderer{})
+}
+
+// RendererAuto is the name used to request automatic backend selection.
+const RendererAuto = "auto"
+
+// RenderWithBackend resolves name (a concrete renderer, or RendererAuto) and
+// renders archive through it. With RendererAuto a failing backend falls back
+// to the poundifdef renderer; a concrete renderer's error is returned as is.
+func RenderWithBackend(ctx context.Context, name string, archive *MyArchive, out io.Writer, opts RenderOptions) error {
+	if name == "" {
+		name = RendererAuto
+	}
+
+	if name == RendererAuto {
+		r := selectAuto(archive)
+		if err := r.Render(ctx, archive, out, opts); err != nil {
+			fallback, _ := Get("poundifdef")
+			if fallback != nil && fallback.Name() != r.Name() {
+				return fallback.Render(ctx, archive, out, opts)
+			}
+			return err
+		}
+		return nil
+	}
+
+	r, ok := Get(name)
+	if !ok {
+		return fmt.Errorf("unknown renderer %q, have: %v", name, Names())
+	}
+	if !r.Supports(archive.Version()) {
+		return fmt.Errorf("renderer %q does not support notebook version %d", name, archive.Version())
+	}
+	return r.Render(ctx, archive, out, opts)
+}
+
+// selectAuto picks the best available backend for the archive's notebook
+// format: the native v6 renderer for v6 scene files, poundifdef otherwise.
+func selectAuto(archive *MyArchive) Renderer {
+	if r, ok := Get("v6native"); ok && r.Supports(archive.Version()) {
+		return r
+	}
+	r, _ := Get("poundifdef")
+	return r
+}
+
+// renderToTempFile adapts the file-path based renderFn (input, output string)
+// to the io.Writer shaped Renderer.Render.
+func renderToTempFile(out io.Writer, renderFn func(input, output string) (io.ReadCloser, error), archivePath string) error {
+	tmp, err := os.CreateTemp("", "rm-export-*.pdf")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	rc, err := renderFn(archivePath, tmpPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+type poundifdefRenderer struct{}
+
+func (*poundifdefRenderer) Name() string { return "poundifdef" }
+func (*poundifdefRenderer) Supports(version int) bool {
+	return version == 5 || version == 6
+}
+func (*poundifdefRenderer) Capabilities() Capabilities {
+	return Capabilities{Color: true}
+}
+func (*poundifdefRenderer) Render(_ context.Context, archive *MyArchive, out io.Writer, _ RenderOptions) error {
+	return renderToTempFile(out, RenderPoundifdef, archive.Path())
+}
+
+type v6NativeRenderer struct{}
+
+func (*v6NativeRenderer) Name() string { return "v6native" }
+func (*v6NativeRenderer) Supports(version int) bool {
+	return version == 6
+}
+func (*v6NativeRenderer) Capabilities() Capabilities {
+	return Capabilities{Pressure: true, PenTypes: true, Templates: true}
+}
+func (*v6NativeRenderer) Render(_ context.Context, archive *MyArchive, out io.Writer, _ RenderOptions) error {
+	return renderToTempFile(out, RenderRmV6, archive.Path())
+}
+
+type rmapiRenderer struct{}
+
+func (*rmapiRenderer) Name() string { return "rmapi" }
+func (*rmapiRenderer) Supports(version int) bool {
+	return version == 5 || version == 6
+}
+func (*rmapiRenderer) Capabilities() Capabilities {
+	return Capabilities{Color: true}
+}
+func (*rmapiRenderer) Render(_ context.Context, archive *MyArchive, out io.Writer, opts RenderOptions) error {
+	pdfgen := PdfGenerator{}
+	return pdfgen.Generate(archive, out, PdfGeneratorOptions{AllPages: opts.AllPages})
+}
@@ -3,13 +3,17 @@ package exporter
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	v6 "github.com/ddvk/reader/v6"
 	"github.com/go-pdf/fpdf"
+	"github.com/go-pdf/fpdf/contrib/gofpdi"
 	rm2pdf "github.com/poundifdef/go-remarkable2pdf"
 	"github.com/sirupsen/logrus"
 )
@@ -51,7 +55,153 @@ func RenderRmapi(a *MyArchive, output io.Writer) error {
 	return pdfgen.Generate(a, output, options)
 }
 
+// brushProfile describes how a PenType should be stroked
+type brushProfile struct {
+	widthScale  float64 // multiplier applied to the point's reported width
+	minWidth    float64
+	opacity     float64 // 0..1, used for highlighter/pencil translucency
+	pressure    bool    // whether pressure should modulate width
+	speedTapers bool    // whether speed should taper the ends (ballpoint/pencil/brush)
+	eraser      bool
+}
+
+// pen types as reported by the v6 scene format
+const (
+	penBallpoint       = 2
+	penBallpointFine   = 15
+	penMarker          = 3
+	penMarkerFine      = 16
+	penFineliner       = 4
+	penPencil          = 1
+	penMechPencil      = 7
+	penPencilTilt      = 14
+	penBrush           = 0
+	penBrushFine       = 12
+	penHighlighter     = 5
+	penHighlighterFine = 18
+	penEraser          = 6
+	penEraseArea       = 8
+	penCalligraphy     = 21
+)
+
+func brushFor(penType int) brushProfile {
+	switch penType {
+	case penBallpoint, penBallpointFine:
+		return brushProfile{widthScale: 0.8, minWidth: 0.25, opacity: 1, pressure: true, speedTapers: true}
+	case penMarker, penMarkerFine:
+		return brushProfile{widthScale: 1.6, minWidth: 0.6, opacity: 1, pressure: true}
+	case penFineliner:
+		return brushProfile{widthScale: 0.9, minWidth: 0.3, opacity: 1}
+	case penPencil, penMechPencil, penPencilTilt:
+		return brushProfile{widthScale: 0.7, minWidth: 0.2, opacity: 0.85, pressure: true, speedTapers: true}
+	case penBrush, penBrushFine:
+		return brushProfile{widthScale: 2.0, minWidth: 0.15, opacity: 1, pressure: true, speedTapers: true}
+	case penHighlighter, penHighlighterFine:
+		return brushProfile{widthScale: 7.5, minWidth: 7.5, opacity: 0.35}
+	case penEraser, penEraseArea:
+		return brushProfile{eraser: true}
+	case penCalligraphy:
+		return brushProfile{widthScale: 1.3, minWidth: 0.4, opacity: 1, pressure: true}
+	default:
+		return brushProfile{widthScale: 1, minWidth: 0.3, opacity: 1}
+	}
+}
+
+// catmullRomToBezier converts 4 consecutive points of a Catmull-Rom spline
+// (p0..p3, interpolating between p1 and p2) into the control points of the
+// equivalent cubic bezier segment.
+func catmullRomToBezier(p0, p1, p2, p3 v6.PenPoint) (c1x, c1y, c2x, c2y float64) {
+	const tension = 6.0
+	c1x = float64(p1.X) + (float64(p2.X)-float64(p0.X))/tension
+	c1y = float64(p1.Y) + (float64(p2.Y)-float64(p0.Y))/tension
+	c2x = float64(p2.X) - (float64(p3.X)-float64(p1.X))/tension
+	c2y = float64(p2.Y) - (float64(p3.Y)-float64(p1.Y))/tension
+	return
+}
+
+// strokeWidth derives the on-page line width for a point, honoring the
+// brush profile, the point's reported pressure and its drawing speed.
+func strokeWidth(b brushProfile, point *v6.PenPoint) float64 {
+	w := float64(point.Width) * b.widthScale
+	if b.pressure {
+		w *= 0.4 + 0.6*(float64(point.Pressure)/255.0)
+	}
+	if b.speedTapers {
+		// faster strokes get thinner, mimicking ballpoint/pencil behavior
+		speedFactor := 1.0 - clamp(float64(point.Speed)/15.0, 0, 0.5)
+		w *= speedFactor
+	}
+	if w < b.minWidth {
+		w = b.minWidth
+	}
+	return w
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// eraserHole returns the page-rectangle-with-a-hole polygon for one eraser
+// stroke: the page outline followed by a reversed-winding loop around the
+// travelled path. PDF/SVG call this the "keyhole" technique - it lets a
+// clip region exclude an area without the clip primitive needing native
+// support for holes.
+func eraserHole(points []*v6.PenPoint) []fpdf.PointType {
+	width := float64(points[0].Width)
+	if width < 10 {
+		width = 10
+	}
+
+	hole := make([]fpdf.PointType, 0, len(points)*2+1)
+	for _, p := range points {
+		hole = append(hole, fpdf.PointType{X: float64(p.X) + DeviceWidth/2 - width/2, Y: float64(p.Y)})
+	}
+	for i := len(points) - 1; i >= 0; i-- {
+		hole = append(hole, fpdf.PointType{X: float64(points[i].X) + DeviceWidth/2 + width/2, Y: float64(points[i].Y)})
+	}
+	// close the ribbon back to its own first point: otherwise the implicit
+	// closure bridges page->hole and hole->page along two *different*
+	// lines (offset by width), which don't cancel under the nonzero-winding
+	// rule and clip out a bogus wedge from the page origin.
+	hole = append(hole, hole[0])
+
+	page := []fpdf.PointType{
+		{X: 0, Y: 0}, {X: DeviceWidth, Y: 0}, {X: DeviceWidth, Y: DeviceHeight}, {X: 0, Y: DeviceHeight}, {X: 0, Y: 0},
+	}
+	return append(append([]fpdf.PointType{}, page...), hole...)
+}
+
+// applyErasers clips out every eraser path on the page before any ink is
+// drawn, so subsequent strokes (the only thing a clip region can actually
+// affect) are cut away in the erased area instead of being painted over
+// with an assumed white fill. Clip regions nest and intersect, so each
+// eraser's hole is additive with the others. The returned func must be
+// called once all ink has been drawn, to pop the clip stack back off.
+func applyErasers(pdf *fpdf.Fpdf, erasers [][]*v6.PenPoint) (restore func()) {
+	for _, points := range erasers {
+		pdf.ClipPolygon(eraserHole(points), false)
+	}
+	return func() {
+		for range erasers {
+			pdf.ClipEnd()
+		}
+	}
+}
+
 func parseSceneFile(file io.ReadCloser) (pdf *fpdf.Fpdf, err error) {
+	return parseSceneFileWithTemplate(file, "")
+}
+
+// parseSceneFileWithTemplate renders a single v6 .rm scene file to a page,
+// optionally compositing the given background template name underneath the
+// strokes.
+func parseSceneFileWithTemplate(file io.ReadCloser, templateName string) (pdf *fpdf.Fpdf, err error) {
 	headerLength := 0x2b
 	buffer := make([]byte, headerLength)
 	_, err = io.ReadFull(file, buffer)
@@ -71,36 +221,320 @@ func parseSceneFile(file io.ReadCloser) (pdf *fpdf.Fpdf, err error) {
 	})
 	pdf.SetLineCapStyle("round")
 	pdf.SetLineJoinStyle("round")
+	pdf.AddPage()
 
-	// fmt.Printf("Number of Layer: %d\n", len(scene.Layers))
+	if templateName != "" {
+		if err := drawTemplate(pdf, templateName); err != nil {
+			logrus.Warnf("can't render template %q: %v", templateName, err)
+		}
+	}
+
+	var erasers [][]*v6.PenPoint
 	for _, layer := range scene.Layers {
+		if layer.Visible != nil && !*layer.Visible {
+			continue
+		}
 		for _, line := range layer.Lines {
-			var lastPoint *v6.PenPoint
-			for _, point := range line.Line.Value.Points {
-				if lastPoint != nil {
-					// logrus.Debug(point.String())
-
-					w := float64(point.Width)
-					fac := 1.0 // (float64(point.Pressure) / 204.8)
-					pdf.SetLineWidth(w * fac / 5)
-
-					x := float64(lastPoint.X + DeviceWidth/2)
-					y := float64(lastPoint.Y)
-					dx := float64(point.X + DeviceWidth/2)
-					dy := float64(point.Y)
-					pdf.Line(x, y, dx, dy)
-				}
-
-				lastPoint = point
+			points := line.Line.Value.Points
+			if len(points) < 2 {
+				continue
+			}
+			if brushFor(int(line.Line.Value.PenType)).eraser {
+				erasers = append(erasers, points)
 			}
 		}
 	}
 
-	logrus.Warn("Drawn all the RECTANNGLESSSSSSSSSSSSSSSS")
+	restore := applyErasers(pdf, erasers)
+	defer restore()
+
+	for _, layer := range scene.Layers {
+		if layer.Visible != nil && !*layer.Visible {
+			continue
+		}
+
+		for _, line := range layer.Lines {
+			points := line.Line.Value.Points
+			if len(points) == 0 {
+				continue
+			}
+
+			brush := brushFor(int(line.Line.Value.PenType))
+			if brush.eraser {
+				continue
+			}
+
+			drawStroke(pdf, brush, points)
+		}
+	}
 
 	return pdf, nil
 }
 
+// drawStroke renders one pen stroke as a Catmull-Rom smoothed curve, with
+// the line width modulated per-segment from pressure/speed. Opacity is the
+// pen type's fixed brush opacity for the whole stroke; it does not vary with
+// per-point pressure or speed.
+func drawStroke(pdf *fpdf.Fpdf, brush brushProfile, points []*v6.PenPoint) {
+	if brush.opacity < 1 {
+		pdf.SetAlpha(brush.opacity, "Normal")
+		defer pdf.SetAlpha(1, "Normal")
+	}
+
+	if len(points) == 1 {
+		w := strokeWidth(brush, points[0])
+		pdf.SetLineWidth(w)
+		x := float64(points[0].X) + DeviceWidth/2
+		y := float64(points[0].Y)
+		pdf.Line(x, y, x, y)
+		return
+	}
+
+	for i := 0; i < len(points)-1; i++ {
+		p0 := pick(points, i-1)
+		p1 := points[i]
+		p2 := points[i+1]
+		p3 := pick(points, i+2)
+
+		w := strokeWidth(brush, p1)
+		pdf.SetLineWidth(w)
+
+		x1 := float64(p1.X) + DeviceWidth/2
+		y1 := float64(p1.Y)
+		x2 := float64(p2.X) + DeviceWidth/2
+		y2 := float64(p2.Y)
+
+		c1x, c1y, c2x, c2y := catmullRomToBezier(*p0, *p1, *p2, *p3)
+		pdf.CurveBezierCubic(x1, y1, c1x+DeviceWidth/2, c1y, c2x+DeviceWidth/2, c2y, x2, y2, "D")
+	}
+}
+
+// pick returns points[i] clamped to the slice bounds, duplicating the edge
+// point so the Catmull-Rom spline has the neighbours it needs at the ends of
+// a stroke.
+func pick(points []*v6.PenPoint, i int) *v6.PenPoint {
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(points) {
+		i = len(points) - 1
+	}
+	return points[i]
+}
+
+// templateContent is the subset of a notebook's .content file we need to
+// locate the background template for a page.
+type templateContent struct {
+	Orientation string `json:"orientation"`
+	CPages      struct {
+		Pages []struct {
+			ID string `json:"id"`
+			// Orientation, when set, overrides the document-level
+			// Orientation for this one page.
+			Orientation string `json:"orientation"`
+			Template    struct {
+				Value string `json:"value"`
+			} `json:"template"`
+		} `json:"pages"`
+	} `json:"cPages"`
+}
+
+// TemplateDir is where drawTemplate looks for a bundled template PDF named
+// "<name>.pdf" to import verbatim, before falling back to a procedural
+// redraw. Overridable so a deployment can ship its own template PDFs.
+var TemplateDir = "templates"
+
+// drawTemplate draws the given named background template (lined, grid,
+// dotted, blank, ...) beneath the strokes of a page. If a matching PDF
+// exists under TemplateDir it's imported as-is; otherwise the template is
+// drawn procedurally from its name so the page always gets a real
+// background rather than silently staying blank.
+func drawTemplate(pdf *fpdf.Fpdf, name string) error {
+	if name == "" || strings.EqualFold(name, "blank") {
+		return nil
+	}
+
+	if path := filepath.Join(TemplateDir, name+".pdf"); fileExists(path) {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("can't open template %q: %w", name, err)
+		}
+		defer f.Close()
+
+		importer := gofpdi.NewImporter()
+		tpl := importer.ImportPageFromStream(pdf, f, 1, "/MediaBox")
+		importer.UseImportedTemplate(pdf, tpl, 0, 0, DeviceWidth, DeviceHeight)
+		return nil
+	}
+
+	drawProceduralTemplate(pdf, name)
+	return nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// drawProceduralTemplate renders a best-effort approximation of the named
+// template directly with fpdf primitives, keyed off common substrings in
+// reMarkable's template names (e.g. "P Lines small", "LS Grid large",
+// "Dotted"). Unrecognized names fall back to blank, same as "Blank" itself.
+func drawProceduralTemplate(pdf *fpdf.Fpdf, name string) {
+	lower := strings.ToLower(name)
+
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.SetLineWidth(0.5)
+
+	switch {
+	case strings.Contains(lower, "grid"):
+		drawGrid(pdf, 28.3, 28.3)
+	case strings.Contains(lower, "dot"):
+		drawDots(pdf, 28.3, 28.3)
+	case strings.Contains(lower, "line"):
+		drawLines(pdf, 36)
+	}
+}
+
+func drawLines(pdf *fpdf.Fpdf, spacing float64) {
+	for y := spacing; y < DeviceHeight; y += spacing {
+		pdf.Line(0, y, DeviceWidth, y)
+	}
+}
+
+func drawGrid(pdf *fpdf.Fpdf, spacingX, spacingY float64) {
+	for x := spacingX; x < DeviceWidth; x += spacingX {
+		pdf.Line(x, 0, x, DeviceHeight)
+	}
+	drawLines(pdf, spacingY)
+}
+
+func drawDots(pdf *fpdf.Fpdf, spacingX, spacingY float64) {
+	pdf.SetFillColor(180, 180, 180)
+	for y := spacingY; y < DeviceHeight; y += spacingY {
+		for x := spacingX; x < DeviceWidth; x += spacingX {
+			pdf.Circle(x, y, 0.6, "F")
+		}
+	}
+}
+
+// RenderRmV6 renders a full notebook archive natively, page by page, using
+// the v6 scene renderer instead of falling back to the poundifdef library.
+// It honors per-page orientation and background templates as declared in
+// the notebook's .content file.
+func RenderRmV6(input, output string) (io.ReadCloser, error) {
+	reader, err := zip.OpenReader(input)
+	if err != nil {
+		return nil, fmt.Errorf("can't open file %w", err)
+	}
+	defer reader.Close()
+
+	content, err := readContent(&reader.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't read notebook content %w", err)
+	}
+
+	writer, err := os.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("can't create outputfile %w", err)
+	}
+
+	out := fpdf.NewCustom(&fpdf.InitType{
+		UnitStr: "pt",
+		Size:    fpdf.SizeType{Wd: DeviceWidth, Ht: DeviceHeight},
+	})
+
+	for _, page := range content.CPages.Pages {
+		rm, err := reader.Open(page.ID + ".rm")
+		if err != nil {
+			logrus.Warnf("page %s has no scene data: %v", page.ID, err)
+			continue
+		}
+
+		pagePdf, err := parseSceneFileWithTemplate(rm, page.Template.Value)
+		rm.Close()
+		if err != nil {
+			logrus.Warnf("can't render page %s: %v", page.ID, err)
+			continue
+		}
+
+		orientation := page.Orientation
+		if orientation == "" {
+			orientation = content.Orientation
+		}
+		if err := appendPage(out, pagePdf, orientation); err != nil {
+			logrus.Warnf("can't append page %s: %v", page.ID, err)
+		}
+	}
+
+	if err := out.Output(writer); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("can't write output %w", err)
+	}
+
+	if _, err := writer.Seek(0, 0); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("can't rewind file %w", err)
+	}
+
+	return writer, nil
+}
+
+// appendPage merges a single rendered page into the output document by
+// round-tripping it through the PDF importer, since fpdf has no native way
+// to splice two independently built documents together. It uses the
+// imported page's actual MediaBox size rather than assuming every page is
+// DeviceWidth x DeviceHeight, and swaps width/height when orientation
+// disagrees with that size so a mixed portrait/landscape notebook doesn't
+// get every page squashed into the same shape.
+func appendPage(out *fpdf.Fpdf, page *fpdf.Fpdf, orientation string) error {
+	var buf bytes.Buffer
+	if err := page.Output(&buf); err != nil {
+		return err
+	}
+
+	importer := gofpdi.NewImporter()
+	tpl := importer.ImportPageFromStream(out, bytes.NewReader(buf.Bytes()), 1, "/MediaBox")
+
+	wd, ht := DeviceWidth, DeviceHeight
+	if box, ok := importer.GetPageSizes()[1]["/MediaBox"]; ok && box["w"] > 0 && box["h"] > 0 {
+		wd, ht = box["w"], box["h"]
+	}
+	switch orientation {
+	case "landscape":
+		if wd < ht {
+			wd, ht = ht, wd
+		}
+	case "portrait":
+		if wd > ht {
+			wd, ht = ht, wd
+		}
+	}
+
+	out.AddPageFormat("", fpdf.SizeType{Wd: wd, Ht: ht})
+	importer.UseImportedTemplate(out, tpl, 0, 0, wd, ht)
+	return nil
+}
+
+func readContent(reader *zip.Reader) (*templateContent, error) {
+	for _, f := range reader.File {
+		if strings.HasSuffix(f.Name, ".content") {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+
+			var c templateContent
+			if err := json.NewDecoder(rc).Decode(&c); err != nil {
+				return nil, err
+			}
+			return &c, nil
+		}
+	}
+	return nil, errors.New("no .content file found in archive")
+}
+
 func RenderCustom(reader io.ReadCloser, output io.Writer) error {
 	if output == nil || reader == nil {
 		return errors.New("reader or writer were nil")
@@ -111,7 +545,6 @@ func RenderCustom(reader io.ReadCloser, output io.Writer) error {
 		return err
 	}
 
-	logrus.Warn("WRITING THE THINGGGGGGGGGGGGGGGGGGGG")
 	err = pdf.Output(output)
 	if err != nil {
 		return err